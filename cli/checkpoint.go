@@ -0,0 +1,155 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// checkpoint records how far a run got through the input CSV, plus a
+// fingerprint of the input file, so a resumed run can tell whether the
+// file underneath it changed before trusting the checkpoint enough to
+// skip lines.
+type checkpoint struct {
+	InputSize  int64     `json:"input_size"`
+	InputMtime time.Time `json:"input_mtime"`
+	LastLine   int       `json:"last_line"`
+}
+
+func fingerprintFile(path string) (int64, time.Time, error) {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return info.Size(), info.ModTime(), nil
+}
+
+// loadCheckpoint returns the checkpoint at path if it exists and its
+// fingerprint matches inputPath's current size and mtime. A missing,
+// corrupt, or stale checkpoint yields (nil, nil) so the caller just starts
+// the run from scratch instead of failing it.
+func loadCheckpoint(path, inputPath string) (*checkpoint, error) {
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var cp checkpoint
+	if err := json.Unmarshal(data, &cp); err != nil {
+		log.Printf("Ignoring unreadable checkpoint %s: %v", path, err)
+		return nil, nil
+	}
+
+	size, mtime, err := fingerprintFile(inputPath)
+	if err != nil {
+		return nil, err
+	}
+	if cp.InputSize != size || !cp.InputMtime.Equal(mtime) {
+		log.Printf("Checkpoint %s doesn't match %s anymore, starting from scratch", path, inputPath)
+		return nil, nil
+	}
+
+	return &cp, nil
+}
+
+// saveCheckpoint persists lastLine via write-temp-then-rename, so a crash
+// mid-write can't corrupt the checkpoint that's already on disk.
+func saveCheckpoint(path, inputPath string, lastLine int) error {
+	size, mtime, err := fingerprintFile(inputPath)
+	if err != nil {
+		return err
+	}
+
+	data, err := json.Marshal(checkpoint{InputSize: size, InputMtime: mtime, LastLine: lastLine})
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(path)
+	tmp, err := os.CreateTemp(dir, filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	tmpName := tmp.Name()
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		os.Remove(tmpName)
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmpName)
+		return err
+	}
+
+	if err := os.Rename(tmpName, path); err != nil {
+		os.Remove(tmpName)
+		return fmt.Errorf("renaming checkpoint into place: %w", err)
+	}
+
+	return nil
+}
+
+// checkpointAck is sent by a worker after each send attempt so the
+// checkpointer goroutine can advance the on-disk checkpoint.
+type checkpointAck struct {
+	line      int
+	succeeded bool
+}
+
+// runCheckpointer drains acks and persists the highest line it can prove is
+// fully accounted for, walking dispatchedLines (the lines sendAll actually
+// handed to workers, in ascending order) rather than assuming line N+1
+// follows line N -- rows readCSV rejected at parse time, or dropped by
+// --category/--id filtering, routinely leave gaps in the line numbers that
+// are never dispatched at all and must not block the watermark. The worker
+// pool dispatches dispatchedLines in order but acks them in whatever order
+// their sends (and retries) finish, so a later line routinely acks before
+// an earlier one still in flight. Advancing past that gap would persist a
+// checkpoint that --resume would trust to skip the still-in-flight line,
+// permanently losing it if the process is killed right after, so
+// out-of-order acks are held in pending until the next expected dispatched
+// line arrives.
+//
+// idx advances past *any* ack, success or terminal failure -- a line that
+// exhausted its retries is just as resolved as one that succeeded (it's
+// already headed for the reject file), and letting it block idx would
+// freeze the checkpoint for the rest of the run even though every later
+// line keeps succeeding. highest (what actually gets persisted) only ever
+// takes the value of a line whose ack succeeded, so a run that resumes
+// after a failed line skips straight past it instead of retrying it
+// forever. It's the only goroutine that writes the checkpoint file.
+func runCheckpointer(acks <-chan checkpointAck, path, inputPath string, dispatchedLines []int) {
+	pending := make(map[int]bool) // line -> succeeded; key presence means acked
+	idx := 0
+	var highest int
+	for ack := range acks {
+		pending[ack.line] = ack.succeeded
+
+		advanced := false
+		for idx < len(dispatchedLines) {
+			line := dispatchedLines[idx]
+			succeeded, acked := pending[line]
+			if !acked {
+				break
+			}
+			delete(pending, line)
+			idx++
+			if succeeded {
+				highest = line
+				advanced = true
+			}
+		}
+		if advanced {
+			if err := saveCheckpoint(path, inputPath, highest); err != nil {
+				log.Printf("Error writing checkpoint %s: %v", path, err)
+			}
+		}
+	}
+}
@@ -2,25 +2,29 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/csv"
+	"encoding/json"
 	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
 	"os"
+	"os/signal"
 	"strings"
 	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/nemes1s/shiny-carnival/internal/httpx"
+	"github.com/nemes1s/shiny-carnival/internal/record"
 )
 
-type Record struct {
-	ID         string
-	AssetName  string
-	IP         string
-	CreatedUTC string
-	Source     string
-	Category   string
-}
+// Record is the sender's local name for the shared wire format, kept as an
+// alias so the rest of this file doesn't need a package qualifier.
+type Record = record.Record
 
 var categoryMap = map[string]string{
 	"contentinjection":  "contentinjection",
@@ -63,27 +67,64 @@ var categoryMap = map[string]string{
 	"valida_accounts": "validaccounts",
 }
 
-func sanitizeCategory(category string) string {
+// sanitizeCategory normalizes category and maps it to its canonical form.
+// The second return value reports whether it was found in categoryMap;
+// readCSV rejects rows where it's false instead of forwarding an unknown
+// category downstream.
+func sanitizeCategory(category string) (string, bool) {
 	normalizedCategory := strings.TrimSpace(strings.ToLower(category))
 	if correctCategory, exists := categoryMap[normalizedCategory]; exists {
-		return correctCategory
+		return correctCategory, true
 	}
 
-	return normalizedCategory
+	return normalizedCategory, false
+}
+
+// sendCounters are the atomic tallies the worker pool bumps as it drains
+// jobs; the progress bar only ever reads them.
+type sendCounters struct {
+	succeeded int64
+	failed    int64
+	skipped   int64
 }
 
 func main() {
 	csvFile := flag.String("file", "data.csv", "Path to the CSV file")
 	filterCategory := flag.String("category", "", "Filter by category (optional)")
 	filterId := flag.String("id", "", "Filter by id (optional)")
+	silent := flag.Bool("silent", false, "Disable the progress bar and log one line per record instead")
+	noProgress := flag.Bool("no-progress", false, "Alias for --silent")
+	resume := flag.Bool("resume", false, "Skip lines already sent according to --checkpoint, if it still matches --file")
+	rejectFile := flag.String("reject-file", "", "Path to the rejected-rows CSV (default: <file>.rejected.csv)")
+	checkpointFile := flag.String("checkpoint", "", "Path to the checkpoint file (default: <file>.checkpoint.json)")
 
 	flag.Parse()
 
-	records, skippedRecords, err := readCSV(*csvFile)
+	if *rejectFile == "" {
+		*rejectFile = *csvFile + ".rejected.csv"
+	}
+	if *checkpointFile == "" {
+		*checkpointFile = *csvFile + ".checkpoint.json"
+	}
+
+	records, rejected, err := readCSV(*csvFile)
 	if err != nil {
 		log.Fatalf("Error reading CSV: %v", err)
 	}
 
+	if *resume {
+		cp, err := loadCheckpoint(*checkpointFile, *csvFile)
+		if err != nil {
+			log.Fatalf("Error loading checkpoint: %v", err)
+		}
+		if cp != nil {
+			before := len(records)
+			records = filterResumed(records, cp.LastLine)
+			log.Printf("Resuming from checkpoint: skipping %d already-sent records (through line %d)",
+				before-len(records), cp.LastLine)
+		}
+	}
+
 	// Filter records if a filter is provided
 	if *filterCategory != "" {
 		records = filterRecordsByCategory(records, *filterCategory)
@@ -93,42 +134,212 @@ func main() {
 		records = filterRecordsById(records, *filterId)
 	}
 
-	jobs := make(chan Record, len(records))
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	sigCh := make(chan os.Signal, 1)
+	signal.Notify(sigCh, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		<-sigCh
+		log.Println("Shutdown requested, draining in-flight requests...")
+		cancel()
+	}()
+
+	quiet := *silent || *noProgress
 
 	numWorkers := 20
+	var counters sendCounters
+
+	var bar *progressBar
+	if !quiet && len(records) > 0 {
+		bar = newProgressBar(len(records), &counters)
+		bar.start()
+	}
+
+	rejected = append(rejected, sendAll(ctx, records, numWorkers, sendRecord, *checkpointFile, *csvFile, &counters, quiet)...)
+
+	if bar != nil {
+		bar.finish()
+	}
+
+	if ctx.Err() != nil {
+		inFlight := int64(len(records)) - counters.succeeded - counters.failed - counters.skipped
+		fmt.Printf("Aborted, %d in-flight cancelled.\n", inFlight)
+	}
+
+	fmt.Printf("Processed %d records (%d succeeded, %d failed, %d skipped).\n",
+		len(records), counters.succeeded, counters.failed, counters.skipped)
+
+	if len(rejected) > 0 {
+		if err := writeRejectedRows(*rejectFile, rejected); err != nil {
+			log.Printf("Error writing rejected-rows file %s: %v", *rejectFile, err)
+		} else {
+			fmt.Printf("Wrote %d rejected rows to %s\n", len(rejected), *rejectFile)
+		}
+	}
+}
+
+// sendAll fans records out across numWorkers goroutines, acking each send
+// attempt to the checkpointer goroutine so it can track progress, and
+// returns the rows that failed after retries. send is injected so tests can
+// drive the pool with a fake, out-of-order-completing sender instead of a
+// real HTTP round trip.
+func sendAll(ctx context.Context, records []parsedRecord, numWorkers int, send func(context.Context, Record) error, checkpointPath, inputPath string, counters *sendCounters, quiet bool) []rejectedRow {
+	jobs := make(chan parsedRecord, len(records))
+	acks := make(chan checkpointAck, len(records))
+
 	var wg sync.WaitGroup
+	var rejectedMu sync.Mutex
+	var rejected []rejectedRow
+
+	dispatchedLines := make([]int, len(records))
+	for i, pr := range records {
+		dispatchedLines[i] = pr.Line
+	}
+
+	checkpointerDone := make(chan struct{})
+	go func() {
+		runCheckpointer(acks, checkpointPath, inputPath, dispatchedLines)
+		close(checkpointerDone)
+	}()
 
 	for i := 0; i < numWorkers; i++ {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			for record := range jobs {
-				if err := sendRecord(record); err != nil {
-					log.Printf("Error sending record %s: %v", record.ID, err)
+			for pr := range jobs {
+				if ctx.Err() != nil {
+					atomic.AddInt64(&counters.skipped, 1)
+					continue
+				}
+
+				if err := send(ctx, pr.Record); err != nil {
+					atomic.AddInt64(&counters.failed, 1)
+					if quiet {
+						log.Printf("Error sending record %s: %v", pr.Record.ID, err)
+					}
+					rejectedMu.Lock()
+					rejected = append(rejected, rejectedRow{
+						Line:   pr.Line,
+						Fields: fieldsOf(pr.Record),
+						Reason: "HTTP send failure after retries",
+					})
+					rejectedMu.Unlock()
+					acks <- checkpointAck{line: pr.Line, succeeded: false}
 				} else {
-					fmt.Printf("Record %s sent successfully.\n", record.ID)
+					atomic.AddInt64(&counters.succeeded, 1)
+					if quiet {
+						fmt.Printf("Record %s sent successfully.\n", pr.Record.ID)
+					}
+					acks <- checkpointAck{line: pr.Line, succeeded: true}
 				}
 			}
 		}()
 	}
 
-	for _, record := range records {
-		jobs <- record
+dispatch:
+	for _, pr := range records {
+		select {
+		case jobs <- pr:
+		case <-ctx.Done():
+			break dispatch
+		}
 	}
 	close(jobs)
 
 	wg.Wait()
+	close(acks)
+	<-checkpointerDone
+
+	return rejected
+}
+
+// progressBar renders a single self-updating line with totals, throughput,
+// ETA, and the succeeded/failed/skipped breakdown, refreshed on a ticker
+// from the atomic counters the worker pool bumps.
+type progressBar struct {
+	total     int
+	counters  *sendCounters
+	startedAt time.Time
+	stopCh    chan struct{}
+	doneCh    chan struct{}
+}
 
-	fmt.Printf("Processed %d records.\n", len(records))
-	if len(skippedRecords) > 0 {
-		fmt.Printf("Skipped %d records due to incorrect field counts:\n", len(skippedRecords))
-		for _, lineNum := range skippedRecords {
-			fmt.Printf(" - Line %d\n", lineNum)
+func newProgressBar(total int, counters *sendCounters) *progressBar {
+	return &progressBar{
+		total:     total,
+		counters:  counters,
+		startedAt: time.Now(),
+		stopCh:    make(chan struct{}),
+		doneCh:    make(chan struct{}),
+	}
+}
+
+func (b *progressBar) start() {
+	go b.run()
+}
+
+func (b *progressBar) run() {
+	defer close(b.doneCh)
+
+	ticker := time.NewTicker(200 * time.Millisecond)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			b.render()
+		case <-b.stopCh:
+			b.render()
+			return
 		}
 	}
 }
 
-func readCSV(filePath string) ([]Record, []int, error) {
+func (b *progressBar) render() {
+	succeeded := atomic.LoadInt64(&b.counters.succeeded)
+	failed := atomic.LoadInt64(&b.counters.failed)
+	skipped := atomic.LoadInt64(&b.counters.skipped)
+	done := succeeded + failed + skipped
+
+	elapsed := time.Since(b.startedAt)
+	rate := float64(done) / elapsed.Seconds()
+
+	var eta time.Duration
+	if rate > 0 && int(done) < b.total {
+		eta = time.Duration(float64(b.total-int(done))/rate) * time.Second
+	}
+
+	pct := 100.0
+	if b.total > 0 {
+		pct = float64(done) / float64(b.total) * 100
+	}
+
+	const barWidth = 30
+	filled := int(pct * barWidth / 100)
+	if filled > barWidth {
+		filled = barWidth
+	}
+
+	fmt.Fprintf(os.Stderr, "\r[%s%s] %3.0f%% %d/%d ok:%d fail:%d skip:%d %.1f rec/s ETA %s",
+		strings.Repeat("=", filled), strings.Repeat(" ", barWidth-filled),
+		pct, done, b.total, succeeded, failed, skipped, rate, eta.Round(time.Second))
+}
+
+func (b *progressBar) finish() {
+	close(b.stopCh)
+	<-b.doneCh
+	fmt.Fprintln(os.Stderr)
+}
+
+// parsedRecord pairs a Record with the input line it came from, so the
+// checkpoint and rejected-rows sidecar can both refer back to the CSV.
+type parsedRecord struct {
+	Record Record
+	Line   int
+}
+
+func readCSV(filePath string) ([]parsedRecord, []rejectedRow, error) {
 	file, err := os.Open(filePath)
 	if err != nil {
 		return nil, nil, err
@@ -139,8 +350,8 @@ func readCSV(filePath string) ([]Record, []int, error) {
 	reader.Comma = ';'
 	reader.FieldsPerRecord = -1 // Allow variable field counts
 
-	var records []Record
-	var skippedRecords []int
+	var records []parsedRecord
+	var rejected []rejectedRow
 	lineNumber := 0
 
 	for {
@@ -155,70 +366,101 @@ func readCSV(filePath string) ([]Record, []int, error) {
 		}
 		if err != nil {
 			fmt.Printf("Error reading line %d: %v\n", lineNumber, err)
-			skippedRecords = append(skippedRecords, lineNumber)
+			rejected = append(rejected, rejectedRow{Line: lineNumber, Reason: "malformed CSV row"})
 			continue
 		}
 
 		if len(row) != 6 {
 			fmt.Printf("Skipping line %d due to incorrect number of fields (expected 6, got %d)\n", lineNumber, len(row))
-			skippedRecords = append(skippedRecords, lineNumber)
+			rejected = append(rejected, rejectedRow{Line: lineNumber, Fields: row, Reason: "bad field count"})
 			continue
 		}
 
-		category := sanitizeCategory(row[5])
+		category, mapped := sanitizeCategory(row[5])
 
 		if category == "" {
 			fmt.Printf("Skipping line %d due to blank category field\n", lineNumber)
-			skippedRecords = append(skippedRecords, lineNumber)
+			rejected = append(rejected, rejectedRow{Line: lineNumber, Fields: row, Reason: "blank category"})
 			continue
 		}
 
-		records = append(records, Record{
-			ID:         row[0],
-			AssetName:  row[1],
-			IP:         row[2],
-			CreatedUTC: row[3],
-			Source:     row[4],
-			Category:   category,
+		if !mapped {
+			fmt.Printf("Skipping line %d due to unmapped category %q\n", lineNumber, category)
+			rejected = append(rejected, rejectedRow{Line: lineNumber, Fields: row, Reason: "unmapped category"})
+			continue
+		}
+
+		records = append(records, parsedRecord{
+			Record: Record{
+				ID:         row[0],
+				AssetName:  row[1],
+				IP:         row[2],
+				CreatedUTC: row[3],
+				Source:     row[4],
+				Category:   category,
+			},
+			Line: lineNumber,
 		})
 	}
 
-	return records, skippedRecords, nil
+	return records, rejected, nil
 }
 
-func filterRecordsByCategory(records []Record, category string) []Record {
-	var filtered []Record
-	for _, record := range records {
-		if strings.EqualFold(record.Category, category) {
-			filtered = append(filtered, record)
+func filterRecordsByCategory(records []parsedRecord, category string) []parsedRecord {
+	var filtered []parsedRecord
+	for _, pr := range records {
+		if strings.EqualFold(pr.Record.Category, category) {
+			filtered = append(filtered, pr)
 		}
 	}
 	return filtered
 }
 
-func filterRecordsById(records []Record, id string) []Record {
-	var filtered []Record
-	for _, record := range records {
-		if strings.EqualFold(record.ID, id) {
-			filtered = append(filtered, record)
+func filterRecordsById(records []parsedRecord, id string) []parsedRecord {
+	var filtered []parsedRecord
+	for _, pr := range records {
+		if strings.EqualFold(pr.Record.ID, id) {
+			filtered = append(filtered, pr)
 		}
 	}
 	return filtered
 }
 
-func sendRecord(record Record) error {
-	url := "http://localhost:8081/process"
-	jsonData := fmt.Sprintf(`{"id":"%s", "asset_name":"%s", "ip":"%s", "created_utc":"%s", "source":"%s", "category":"%s"}`,
-		record.ID, record.AssetName, record.IP, record.CreatedUTC, record.Source, record.Category)
+// filterResumed drops every record at or before the checkpoint's last
+// successfully-sent line.
+func filterResumed(records []parsedRecord, lastLine int) []parsedRecord {
+	var filtered []parsedRecord
+	for _, pr := range records {
+		if pr.Line > lastLine {
+			filtered = append(filtered, pr)
+		}
+	}
+	return filtered
+}
+
+// fieldsOf reconstructs the raw CSV fields for a Record, for the
+// rejected-rows sidecar when a send fails after all retries.
+func fieldsOf(rec Record) []string {
+	return []string{rec.ID, rec.AssetName, rec.IP, rec.CreatedUTC, rec.Source, rec.Category}
+}
+
+var senderClient = httpx.New(httpx.DefaultConfig())
 
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer([]byte(jsonData)))
+func sendRecord(ctx context.Context, rec Record) error {
+	url := "http://localhost:8081/process"
+	jsonData, err := json.Marshal(rec)
 	if err != nil {
-		return err
+		return fmt.Errorf("marshalling record %s: %w", rec.ID, err)
 	}
-	req.Header.Set("Content-Type", "application/json")
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := senderClient.Do(ctx, "microservice", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(jsonData))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	})
 	if err != nil {
 		return err
 	}
@@ -226,8 +468,6 @@ func sendRecord(record Record) error {
 
 	if resp.StatusCode != http.StatusOK {
 		return fmt.Errorf("failed to send record, status: %d", resp.StatusCode)
-	} else {
-		fmt.Printf("Record %s sent successfully.\n", record.ID)
 	}
 	return nil
 }
@@ -0,0 +1,375 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/nemes1s/shiny-carnival/internal/record"
+)
+
+var errPermanentSendFailure = errors.New("simulated permanent send failure")
+
+func writeTempCSV(t *testing.T, contents string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "data.csv")
+	if err := os.WriteFile(path, []byte(contents), 0o644); err != nil {
+		t.Fatalf("writing temp CSV: %v", err)
+	}
+	return path
+}
+
+func TestReadCSV_QuotedFieldsWithEmbeddedSemicolons(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\n" +
+		`1;"server;rack-12";10.0.0.1;2024-01-01T00:00:00Z;edr;phishing` + "\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no skipped records, got %v", rejected)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if got, want := records[0].Record.AssetName, "server;rack-12"; got != want {
+		t.Errorf("AssetName = %q, want %q", got, want)
+	}
+}
+
+func TestReadCSV_UTF8BOM(t *testing.T) {
+	bom := "\xef\xbb\xbf"
+	csvData := bom + "id;asset_name;ip;created_utc;source;category\n" +
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no skipped records, got %v", rejected)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if got, want := records[0].Record.ID, "1"; got != want {
+		t.Errorf("ID = %q, want %q", got, want)
+	}
+}
+
+func TestReadCSV_CRLFLineEndings(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\r\n" +
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\r\n" +
+		"2;asset-b;10.0.0.2;2024-01-01T00:00:01Z;edr;validaccounts\r\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no skipped records, got %v", rejected)
+	}
+	if len(records) != 2 {
+		t.Fatalf("expected 2 records, got %d", len(records))
+	}
+	if got, want := records[1].Record.CreatedUTC, "2024-01-01T00:00:01Z"; got != want {
+		t.Errorf("CreatedUTC = %q, want %q", got, want)
+	}
+}
+
+func TestReadCSV_EmbeddedQuotes(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\n" +
+		`1;"web-server ""prod""";10.0.0.1;2024-01-01T00:00:00Z;edr;phishing` + "\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no skipped records, got %v", rejected)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+	if got, want := records[0].Record.AssetName, `web-server "prod"`; got != want {
+		t.Errorf("AssetName = %q, want %q", got, want)
+	}
+}
+
+func TestReadCSV_EmptyTrailingColumn(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\n" +
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;\n"
+
+	path := writeTempCSV(t, csvData)
+	_, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 1 {
+		t.Fatalf("expected the blank-category row to be skipped, got %v", rejected)
+	}
+}
+
+// TestReadCSV_RoundTripsIntoWireFormat verifies that an adversarial record
+// survives readCSV -> json.Marshal -> json.Unmarshal into the microservice's
+// record.Record with no field corruption.
+func TestReadCSV_RoundTripsIntoWireFormat(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\n" +
+		`1;"quote""; semicolon; and
+newline";10.0.0.1;2024-01-01T00:00:00Z;edr;phishing` + "\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no skipped records, got %v", rejected)
+	}
+	if len(records) != 1 {
+		t.Fatalf("expected 1 record, got %d", len(records))
+	}
+
+	body, err := json.Marshal(records[0].Record)
+	if err != nil {
+		t.Fatalf("json.Marshal: %v", err)
+	}
+
+	var decoded record.Record
+	if err := json.Unmarshal(body, &decoded); err != nil {
+		t.Fatalf("json.Unmarshal into record.Record: %v", err)
+	}
+
+	if decoded.AssetName != records[0].Record.AssetName {
+		t.Errorf("AssetName round-trip mismatch: got %q, want %q", decoded.AssetName, records[0].Record.AssetName)
+	}
+	if decoded.ID != records[0].Record.ID {
+		t.Errorf("ID round-trip mismatch: got %q, want %q", decoded.ID, records[0].Record.ID)
+	}
+}
+
+func TestReadCSV_UnmappedCategoryIsRejected(t *testing.T) {
+	csvData := "id;asset_name;ip;created_utc;source;category\n" +
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;not-a-real-category\n"
+
+	path := writeTempCSV(t, csvData)
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(records) != 0 {
+		t.Fatalf("expected no records, got %d", len(records))
+	}
+	if len(rejected) != 1 || rejected[0].Reason != "unmapped category" {
+		t.Fatalf("expected one rejected row with reason %q, got %+v", "unmapped category", rejected)
+	}
+}
+
+func TestCheckpoint_ResumeSkipsProcessedLines(t *testing.T) {
+	path := writeTempCSV(t, "id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"+
+		"2;asset-b;10.0.0.2;2024-01-01T00:00:01Z;edr;phishing\n")
+
+	checkpointPath := path + ".checkpoint.json"
+	if err := saveCheckpoint(checkpointPath, path, 2); err != nil {
+		t.Fatalf("saveCheckpoint: %v", err)
+	}
+
+	cp, err := loadCheckpoint(checkpointPath, path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if cp == nil {
+		t.Fatal("expected a checkpoint, got nil")
+	}
+
+	records, _, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	resumed := filterResumed(records, cp.LastLine)
+	if len(resumed) != 1 {
+		t.Fatalf("expected only the line after the checkpoint to remain, got %d left", len(resumed))
+	}
+	if resumed[0].Line != 3 {
+		t.Fatalf("expected line 3 to remain, got line %d", resumed[0].Line)
+	}
+}
+
+func TestCheckpoint_StaleWhenInputChanges(t *testing.T) {
+	path := writeTempCSV(t, "id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n")
+
+	checkpointPath := path + ".checkpoint.json"
+	if err := saveCheckpoint(checkpointPath, path, 1); err != nil {
+		t.Fatalf("saveCheckpoint: %v", err)
+	}
+
+	// Touch the input so its fingerprint no longer matches the checkpoint.
+	if err := os.WriteFile(path, []byte("id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"+
+		"2;asset-b;10.0.0.2;2024-01-01T00:00:01Z;edr;phishing\n"), 0o644); err != nil {
+		t.Fatalf("rewriting input CSV: %v", err)
+	}
+
+	cp, err := loadCheckpoint(checkpointPath, path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if cp != nil {
+		t.Fatalf("expected a stale checkpoint to be ignored, got %+v", cp)
+	}
+}
+
+// TestSendAll_OutOfOrderCompletionsDontSkipAheadInCheckpoint drives the real
+// concurrent worker pool with a sender that deliberately finishes line 2
+// last, so lines 3 and 4 ack first. If the checkpoint advanced on the
+// highest acked line instead of the highest contiguous one, it would jump to
+// line 4 while line 2 is still in flight -- and a crash at that moment would
+// make --resume skip line 2 forever, without it ever reaching the reject
+// file either.
+func TestSendAll_OutOfOrderCompletionsDontSkipAheadInCheckpoint(t *testing.T) {
+	path := writeTempCSV(t, "id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"+
+		"2;asset-b;10.0.0.2;2024-01-01T00:00:01Z;edr;phishing\n"+
+		"3;asset-c;10.0.0.3;2024-01-01T00:00:02Z;edr;phishing\n")
+	checkpointPath := path + ".checkpoint.json"
+
+	records := []parsedRecord{
+		{Line: 2, Record: Record{ID: "1"}},
+		{Line: 3, Record: Record{ID: "2"}},
+		{Line: 4, Record: Record{ID: "3"}},
+	}
+
+	block := make(chan struct{})
+	send := func(ctx context.Context, rec Record) error {
+		if rec.ID == "1" {
+			<-block
+		}
+		return nil
+	}
+
+	var counters sendCounters
+	resultCh := make(chan []rejectedRow, 1)
+	go func() {
+		resultCh <- sendAll(context.Background(), records, len(records), send, checkpointPath, path, &counters, true)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for atomic.LoadInt64(&counters.succeeded) < 2 {
+		if time.Now().After(deadline) {
+			t.Fatal("timed out waiting for lines 3 and 4 to complete")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	// Give the checkpointer goroutine a moment to fold in both acks.
+	time.Sleep(20 * time.Millisecond)
+
+	if cp, err := loadCheckpoint(checkpointPath, path); err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	} else if cp != nil {
+		t.Fatalf("expected no checkpoint while line 2 is still in flight, got %+v", cp)
+	}
+
+	close(block)
+	select {
+	case <-resultCh:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for sendAll to finish")
+	}
+
+	cp, err := loadCheckpoint(checkpointPath, path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if cp == nil || cp.LastLine != 4 {
+		t.Fatalf("expected the checkpoint to reach line 4 once line 2 completes, got %+v", cp)
+	}
+}
+
+// TestSendAll_GapFromRejectedRowDoesntFreezeCheckpoint covers a file where a
+// row in the middle was rejected at parse time (e.g. bad field count) and so
+// was never dispatched at all -- its line number is a permanent gap, not a
+// still-in-flight record, and must not block the watermark from advancing
+// past it once every dispatched line after it succeeds.
+func TestSendAll_GapFromRejectedRowDoesntFreezeCheckpoint(t *testing.T) {
+	path := writeTempCSV(t, "id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"+
+		"2;bad\n"+
+		"3;asset-c;10.0.0.3;2024-01-01T00:00:02Z;edr;phishing\n")
+	checkpointPath := path + ".checkpoint.json"
+
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 1 || rejected[0].Line != 3 {
+		t.Fatalf("expected line 3 to be rejected at parse time, got %+v", rejected)
+	}
+	if len(records) != 2 || records[0].Line != 2 || records[1].Line != 4 {
+		t.Fatalf("expected dispatched lines 2 and 4 with a gap at line 3, got %+v", records)
+	}
+
+	send := func(ctx context.Context, rec Record) error { return nil }
+
+	var counters sendCounters
+	sendAll(context.Background(), records, len(records), send, checkpointPath, path, &counters, true)
+
+	cp, err := loadCheckpoint(checkpointPath, path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if cp == nil || cp.LastLine != 4 {
+		t.Fatalf("expected the checkpoint to reach line 4 despite the gap at line 3, got %+v", cp)
+	}
+}
+
+// TestSendAll_PermanentlyFailedLineDoesntFreezeCheckpoint covers a line that
+// *was* dispatched but exhausted its retries (unlike the parse-time-rejected
+// gap above, which is never dispatched at all). It must not block later
+// successes from advancing the watermark, and the watermark must jump past
+// it rather than getting stuck waiting for a success that will never come.
+func TestSendAll_PermanentlyFailedLineDoesntFreezeCheckpoint(t *testing.T) {
+	path := writeTempCSV(t, "id;asset_name;ip;created_utc;source;category\n"+
+		"1;asset-a;10.0.0.1;2024-01-01T00:00:00Z;edr;phishing\n"+
+		"2;asset-b;10.0.0.2;2024-01-01T00:00:01Z;edr;phishing\n"+
+		"3;asset-c;10.0.0.3;2024-01-01T00:00:02Z;edr;phishing\n"+
+		"4;asset-d;10.0.0.4;2024-01-01T00:00:03Z;edr;phishing\n")
+	checkpointPath := path + ".checkpoint.json"
+
+	records, rejected, err := readCSV(path)
+	if err != nil {
+		t.Fatalf("readCSV: %v", err)
+	}
+	if len(rejected) != 0 {
+		t.Fatalf("expected no parse-time rejects, got %+v", rejected)
+	}
+
+	send := func(ctx context.Context, rec Record) error {
+		if rec.ID == "2" {
+			return errPermanentSendFailure
+		}
+		return nil
+	}
+
+	var counters sendCounters
+	sendAll(context.Background(), records, len(records), send, checkpointPath, path, &counters, true)
+
+	cp, err := loadCheckpoint(checkpointPath, path)
+	if err != nil {
+		t.Fatalf("loadCheckpoint: %v", err)
+	}
+	if cp == nil || cp.LastLine != 5 {
+		t.Fatalf("expected the checkpoint to reach line 5 despite line 3's permanent failure, got %+v", cp)
+	}
+}
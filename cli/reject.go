@@ -0,0 +1,57 @@
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+)
+
+// rejectedRow records why a single CSV line was never sent to the
+// microservice: either it never parsed (bad field count, blank or
+// unmapped category) or it parsed fine but sending it failed after all
+// retries.
+type rejectedRow struct {
+	Line   int
+	Fields []string // id, asset_name, ip, created_utc, source, category
+	Reason string
+}
+
+// writeRejectedRows writes the sidecar rejected-rows CSV: every original
+// field plus the line number and a trailing reason column. It's a no-op
+// when there's nothing to report.
+func writeRejectedRows(path string, rows []rejectedRow) error {
+	if len(rows) == 0 {
+		return nil
+	}
+
+	file, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	w := csv.NewWriter(file)
+	w.Comma = ';'
+	defer w.Flush()
+
+	header := []string{"line", "id", "asset_name", "ip", "created_utc", "source", "category", "reason"}
+	if err := w.Write(header); err != nil {
+		return err
+	}
+
+	for _, row := range rows {
+		fields := make([]string, 6)
+		copy(fields, row.Fields)
+
+		out := make([]string, 0, len(header))
+		out = append(out, strconv.Itoa(row.Line))
+		out = append(out, fields...)
+		out = append(out, row.Reason)
+
+		if err := w.Write(out); err != nil {
+			return err
+		}
+	}
+
+	return w.Error()
+}
@@ -0,0 +1,111 @@
+// Package batcher provides a generic size-or-time batcher: a batch flushes
+// as soon as either bound is hit, whichever comes first.
+package batcher
+
+import (
+	"sync"
+	"time"
+)
+
+// Batcher accumulates items and calls Flush when either Size items have
+// been added, or MaxLatency has elapsed since the first item in the
+// current batch arrived — not since the last flush. That means a lone
+// item ships after at most MaxLatency, while a burst ships as soon as it
+// fills a batch, regardless of how recently the previous one flushed.
+type Batcher[T any] struct {
+	size       int
+	maxLatency time.Duration
+	flush      func([]T) error
+	onError    func(error)
+
+	mu    sync.Mutex
+	buf   []T
+	timer *time.Timer
+}
+
+// New returns a Batcher that calls flush with each completed batch. flush
+// is invoked synchronously from whichever goroutine completes the batch
+// (Add or the latency timer), so it should not block for long.
+func New[T any](size int, maxLatency time.Duration, flush func([]T) error) *Batcher[T] {
+	return &Batcher[T]{
+		size:       size,
+		maxLatency: maxLatency,
+		flush:      flush,
+	}
+}
+
+// OnError registers a callback invoked with the error returned by flush,
+// if any. It is optional; errors are otherwise dropped since flush is
+// expected to handle its own failure path (e.g. dead-lettering).
+func (b *Batcher[T]) OnError(fn func(error)) {
+	b.mu.Lock()
+	b.onError = fn
+	b.mu.Unlock()
+}
+
+// Add appends item to the current batch, starting the latency timer if
+// this is the first item since the last flush, and flushes immediately
+// once the batch reaches Size.
+func (b *Batcher[T]) Add(item T) {
+	b.mu.Lock()
+	b.buf = append(b.buf, item)
+
+	var toFlush []T
+	switch {
+	case len(b.buf) >= b.size:
+		toFlush = b.buf
+		b.buf = nil
+		b.stopTimerLocked()
+	case len(b.buf) == 1:
+		b.timer = time.AfterFunc(b.maxLatency, b.flushOnTimer)
+	}
+	b.mu.Unlock()
+
+	if toFlush != nil {
+		b.doFlush(toFlush)
+	}
+}
+
+func (b *Batcher[T]) flushOnTimer() {
+	b.mu.Lock()
+	toFlush := b.buf
+	b.buf = nil
+	b.timer = nil
+	b.mu.Unlock()
+
+	if len(toFlush) > 0 {
+		b.doFlush(toFlush)
+	}
+}
+
+func (b *Batcher[T]) stopTimerLocked() {
+	if b.timer != nil {
+		b.timer.Stop()
+		b.timer = nil
+	}
+}
+
+func (b *Batcher[T]) doFlush(items []T) {
+	err := b.flush(items)
+	if err != nil {
+		b.mu.Lock()
+		onError := b.onError
+		b.mu.Unlock()
+		if onError != nil {
+			onError(err)
+		}
+	}
+}
+
+// Close flushes any items currently buffered, bypassing the latency timer.
+func (b *Batcher[T]) Close() {
+	b.mu.Lock()
+	toFlush := b.buf
+	b.buf = nil
+	b.stopTimerLocked()
+	b.mu.Unlock()
+
+	if len(toFlush) > 0 {
+		b.doFlush(toFlush)
+	}
+}
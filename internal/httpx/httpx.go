@@ -0,0 +1,210 @@
+// Package httpx wraps a shared *http.Client with per-request deadlines,
+// full jittered exponential backoff, and a per-endpoint circuit breaker, so
+// callers stop hand-rolling retry loops around bare http.Client{} instances.
+package httpx
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// ErrCircuitOpen is returned by Do without attempting a request when the
+// endpoint's circuit breaker is tripped.
+var ErrCircuitOpen = errors.New("httpx: circuit breaker open, endpoint is cooling down")
+
+// Config controls the retry, backoff, and circuit breaker behavior of a
+// Client.
+type Config struct {
+	// RequestTimeout bounds each individual attempt via context.WithTimeout,
+	// separate from the lifetime of the caller's context.
+	RequestTimeout time.Duration
+	// MaxAttempts is the total number of tries, including the first.
+	MaxAttempts int
+	// BaseBackoff and MaxBackoff bound the jittered exponential sleep
+	// between attempts: sleep = rand[0, min(MaxBackoff, BaseBackoff*2^n)).
+	BaseBackoff time.Duration
+	MaxBackoff  time.Duration
+	// CircuitThreshold is the number of consecutive failures that trips the
+	// breaker for an endpoint; CircuitCooldown is how long it then
+	// short-circuits calls before allowing another attempt.
+	CircuitThreshold int
+	CircuitCooldown  time.Duration
+}
+
+// DefaultConfig returns sane defaults for an outbound JSON API call.
+func DefaultConfig() Config {
+	return Config{
+		RequestTimeout:   10 * time.Second,
+		MaxAttempts:      3,
+		BaseBackoff:      200 * time.Millisecond,
+		MaxBackoff:       5 * time.Second,
+		CircuitThreshold: 5,
+		CircuitCooldown:  30 * time.Second,
+	}
+}
+
+// Client is a retrying, circuit-breaking wrapper around a shared
+// *http.Client. Breakers are tracked per endpoint key so one flaky
+// downstream doesn't trip calls to another.
+type Client struct {
+	http *http.Client
+	cfg  Config
+
+	mu       sync.Mutex
+	breakers map[string]*breaker
+}
+
+type breaker struct {
+	consecutiveFailures int
+	openUntil           time.Time
+}
+
+// New returns a Client backed by a single shared *http.Client.
+func New(cfg Config) *Client {
+	return &Client{
+		http:     &http.Client{},
+		cfg:      cfg,
+		breakers: make(map[string]*breaker),
+	}
+}
+
+// RequestFunc builds a fresh *http.Request for a single attempt. It's a
+// factory rather than a plain *http.Request because request bodies can't be
+// replayed across retries.
+type RequestFunc func(ctx context.Context) (*http.Request, error)
+
+// Do executes requests built by newRequest against endpoint, retrying on
+// 5xx responses, 429, and network errors with full jittered exponential
+// backoff, honoring Retry-After on 429. It gives up early, without
+// attempting a request, if endpoint's circuit breaker is open, and aborts
+// immediately if ctx is cancelled.
+func (c *Client) Do(ctx context.Context, endpoint string, newRequest RequestFunc) (*http.Response, error) {
+	if open, cooldown := c.breakerOpen(endpoint); open {
+		return nil, fmt.Errorf("%w: %s, retry in %s", ErrCircuitOpen, endpoint, cooldown.Round(time.Second))
+	}
+
+	var lastErr error
+	for attempt := 0; attempt < c.cfg.MaxAttempts; attempt++ {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		req, err := newRequest(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		attemptCtx, cancel := context.WithTimeout(ctx, c.cfg.RequestTimeout)
+		req = req.WithContext(attemptCtx)
+		resp, err := c.http.Do(req)
+		cancel()
+
+		if err == nil && resp.StatusCode < 500 && resp.StatusCode != http.StatusTooManyRequests {
+			c.recordSuccess(endpoint)
+			return resp, nil
+		}
+
+		if err != nil {
+			lastErr = err
+		} else {
+			lastErr = fmt.Errorf("httpx: unexpected status %d", resp.StatusCode)
+		}
+
+		retryAfter := time.Duration(0)
+		if resp != nil {
+			if resp.StatusCode == http.StatusTooManyRequests {
+				retryAfter = parseRetryAfter(resp.Header.Get("Retry-After"))
+			}
+			resp.Body.Close()
+		}
+
+		c.recordFailure(endpoint)
+
+		if attempt == c.cfg.MaxAttempts-1 {
+			break
+		}
+
+		wait := retryAfter
+		if wait == 0 {
+			wait = jitteredBackoff(c.cfg.BaseBackoff, c.cfg.MaxBackoff, attempt)
+		}
+
+		select {
+		case <-time.After(wait):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	return nil, lastErr
+}
+
+func (c *Client) breakerOpen(endpoint string) (bool, time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[endpoint]
+	if !ok || b.openUntil.IsZero() {
+		return false, 0
+	}
+	if time.Now().Before(b.openUntil) {
+		return true, time.Until(b.openUntil)
+	}
+	// Cooldown elapsed: allow a probe attempt through.
+	return false, 0
+}
+
+func (c *Client) recordSuccess(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if b, ok := c.breakers[endpoint]; ok {
+		b.consecutiveFailures = 0
+		b.openUntil = time.Time{}
+	}
+}
+
+func (c *Client) recordFailure(endpoint string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	b, ok := c.breakers[endpoint]
+	if !ok {
+		b = &breaker{}
+		c.breakers[endpoint] = b
+	}
+	b.consecutiveFailures++
+	if b.consecutiveFailures >= c.cfg.CircuitThreshold {
+		b.openUntil = time.Now().Add(c.cfg.CircuitCooldown)
+	}
+}
+
+func jitteredBackoff(base, max time.Duration, attempt int) time.Duration {
+	upper := base * (1 << uint(attempt))
+	if upper > max || upper <= 0 {
+		upper = max
+	}
+	if upper <= 0 {
+		return 0
+	}
+	return time.Duration(rand.Int63n(int64(upper)))
+}
+
+func parseRetryAfter(header string) time.Duration {
+	if header == "" {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(header); err == nil {
+		return time.Duration(seconds) * time.Second
+	}
+	if when, err := http.ParseTime(header); err == nil {
+		return time.Until(when)
+	}
+	return 0
+}
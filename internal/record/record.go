@@ -0,0 +1,18 @@
+// Package record defines the wire format shared by the CSV sender and the
+// microservice, so the two binaries can't drift on field names or types.
+package record
+
+// Record is a single security event as it travels from the CSV sender,
+// through the microservice's enrichment step, to Analytics. ASN and
+// CorrelationID are populated by enrichment and are zero-valued on the
+// wire until then.
+type Record struct {
+	ID            string `json:"id"`
+	AssetName     string `json:"asset_name"`
+	IP            string `json:"ip"`
+	CreatedUTC    string `json:"created_utc"`
+	Source        string `json:"source"`
+	Category      string `json:"category"`
+	ASN           string `json:"asn"`
+	CorrelationID int    `json:"correlationId"`
+}
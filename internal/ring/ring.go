@@ -0,0 +1,100 @@
+// Package ring provides a bounded ring buffer used to decouple a producer
+// from a slower consumer without letting the producer block forever.
+package ring
+
+import "sync"
+
+// Mode selects what Push does once the buffer is full.
+type Mode int
+
+const (
+	// Block makes Push wait until the consumer frees up room.
+	Block Mode = iota
+	// DropOldest makes Push evict the oldest buffered item to make room
+	// for the new one, so producers are never held up.
+	DropOldest
+)
+
+// Buffer is a bounded, concurrency-safe FIFO of capacity Cap.
+type Buffer[T any] struct {
+	mode Mode
+	cap  int
+
+	mu     sync.Mutex
+	cond   *sync.Cond
+	buf    []T
+	closed bool
+}
+
+// New returns a Buffer holding at most capacity items, applying mode once
+// it's full.
+func New[T any](capacity int, mode Mode) *Buffer[T] {
+	b := &Buffer[T]{mode: mode, cap: capacity}
+	b.cond = sync.NewCond(&b.mu)
+	return b
+}
+
+// Push adds item to the buffer. In Block mode it waits for room; in
+// DropOldest mode it evicts the oldest buffered item instead of blocking.
+// Push on a closed buffer is a no-op.
+func (b *Buffer[T]) Push(item T) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.closed {
+		return
+	}
+
+	if b.mode == DropOldest {
+		if len(b.buf) >= b.cap {
+			b.buf = b.buf[1:]
+		}
+		b.buf = append(b.buf, item)
+		b.cond.Signal()
+		return
+	}
+
+	for len(b.buf) >= b.cap && !b.closed {
+		b.cond.Wait()
+	}
+	if b.closed {
+		return
+	}
+	b.buf = append(b.buf, item)
+	b.cond.Signal()
+}
+
+// Pop removes and returns the oldest item, blocking until one is available
+// or the buffer is closed (ok is false in the latter case).
+func (b *Buffer[T]) Pop() (item T, ok bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for len(b.buf) == 0 && !b.closed {
+		b.cond.Wait()
+	}
+	if len(b.buf) == 0 {
+		return item, false
+	}
+
+	item = b.buf[0]
+	b.buf = b.buf[1:]
+	b.cond.Signal()
+	return item, true
+}
+
+// Len returns the number of items currently buffered.
+func (b *Buffer[T]) Len() int {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return len(b.buf)
+}
+
+// Close unblocks any pending Push/Pop calls; subsequent Pushes are dropped
+// and Pops drain the remaining buffer before returning ok=false.
+func (b *Buffer[T]) Close() {
+	b.mu.Lock()
+	b.closed = true
+	b.mu.Unlock()
+	b.cond.Broadcast()
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/nemes1s/shiny-carnival/internal/batcher"
+	"github.com/nemes1s/shiny-carnival/internal/httpx"
+	"github.com/nemes1s/shiny-carnival/internal/ring"
+)
+
+var analyticsClient = httpx.New(httpx.DefaultConfig())
+
+// recordBuffer sits between processHandler and the analytics batcher,
+// applying backpressure so a slow or down Analytics Service can't make
+// /process block forever on an unbounded channel.
+var recordBuffer *ring.Buffer[Record]
+
+var (
+	batchesSent   int64
+	itemsIngested int64
+	dlqSize       int64
+)
+
+var (
+	dlqMu   sync.Mutex
+	dlqPath string
+)
+
+// analyticsBatcher is the Batcher recordBuffer drains into; kept at
+// package scope so stopAnalyticsPipeline can flush its in-progress batch
+// on shutdown.
+var analyticsBatcher *batcher.Batcher[Record]
+
+// drainDone is closed once the goroutine started by startAnalyticsPipeline
+// has drained recordBuffer down to nothing after it's closed, so
+// stopAnalyticsPipeline knows it's safe to flush the batcher.
+var drainDone = make(chan struct{})
+
+// startAnalyticsPipeline wires recordBuffer into a size-or-time Batcher and
+// starts the goroutine that drains the buffer into it.
+func startAnalyticsPipeline(batchSize int, maxLatency time.Duration, path string) {
+	dlqPath = path
+
+	if n, err := countDLQEntries(path); err != nil {
+		log.Printf("Error inspecting dead-letter file %s: %v", path, err)
+	} else {
+		atomic.StoreInt64(&dlqSize, n)
+	}
+
+	b := batcher.New[Record](batchSize, maxLatency, flushBatch)
+	b.OnError(func(err error) {
+		log.Printf("Failed to send batch to Analytics Service: %v", err)
+	})
+	analyticsBatcher = b
+
+	go func() {
+		defer close(drainDone)
+		for {
+			record, ok := recordBuffer.Pop()
+			if !ok {
+				return
+			}
+			b.Add(record)
+		}
+	}()
+}
+
+// stopAnalyticsPipeline closes recordBuffer and waits for the drain
+// goroutine to empty it into analyticsBatcher, then closes the batcher so
+// whatever partial batch it's holding flushes (falling back to the
+// dead-letter file if Analytics can't be reached) instead of being
+// dropped on exit.
+func stopAnalyticsPipeline() {
+	recordBuffer.Close()
+	<-drainDone
+	analyticsBatcher.Close()
+}
+
+func flushBatch(records []Record) error {
+	if err := sendToAnalytics(context.Background(), records); err != nil {
+		if dlqErr := appendToDeadLetter(records); dlqErr != nil {
+			log.Printf("Error writing %d records to dead-letter file %s: %v", len(records), dlqPath, dlqErr)
+		}
+		return err
+	}
+
+	atomic.AddInt64(&batchesSent, 1)
+	atomic.AddInt64(&itemsIngested, int64(len(records)))
+	return nil
+}
+
+// appendToDeadLetter records a failed batch as one NDJSON line so it can be
+// replayed later via /replay-dlq without losing the records forever.
+func appendToDeadLetter(records []Record) error {
+	dlqMu.Lock()
+	defer dlqMu.Unlock()
+
+	file, err := os.OpenFile(dlqPath, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	if err := json.NewEncoder(file).Encode(records); err != nil {
+		return err
+	}
+
+	atomic.AddInt64(&dlqSize, 1)
+	return nil
+}
+
+func countDLQEntries(path string) (int64, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var count int64
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		count++
+	}
+	return count, scanner.Err()
+}
+
+// replayDLQHandler re-enqueues every batch recorded in the dead-letter file
+// back onto recordBuffer, then clears the file.
+func replayDLQHandler(w http.ResponseWriter, r *http.Request) {
+	dlqMu.Lock()
+	file, err := os.Open(dlqPath)
+	if os.IsNotExist(err) {
+		dlqMu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(map[string]int{"requeued": 0})
+		return
+	}
+	if err != nil {
+		dlqMu.Unlock()
+		http.Error(w, "failed to open dead-letter file", http.StatusInternalServerError)
+		return
+	}
+
+	var batches [][]Record
+	scanner := bufio.NewScanner(file)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		var records []Record
+		if err := json.Unmarshal(scanner.Bytes(), &records); err != nil {
+			log.Printf("Skipping malformed dead-letter entry: %v", err)
+			continue
+		}
+		batches = append(batches, records)
+	}
+	file.Close()
+	dlqMu.Unlock()
+
+	requeued := 0
+	for _, records := range batches {
+		for _, record := range records {
+			recordBuffer.Push(record)
+			requeued++
+		}
+	}
+
+	dlqMu.Lock()
+	if err := os.Remove(dlqPath); err != nil && !os.IsNotExist(err) {
+		log.Printf("Error clearing dead-letter file %s after replay: %v", dlqPath, err)
+	}
+	atomic.StoreInt64(&dlqSize, 0)
+	dlqMu.Unlock()
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]int{"requeued": requeued})
+}
+
+// metricsHandler exposes Prometheus-style counters for the analytics
+// pipeline: batches sent, items ingested, dead-letter backlog, and items
+// currently buffered awaiting a flush.
+func metricsHandler(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+
+	fmt.Fprintln(w, "# HELP shiny_carnival_batches_sent_total Batches successfully sent to the Analytics Service.")
+	fmt.Fprintln(w, "# TYPE shiny_carnival_batches_sent_total counter")
+	fmt.Fprintf(w, "shiny_carnival_batches_sent_total %d\n", atomic.LoadInt64(&batchesSent))
+
+	fmt.Fprintln(w, "# HELP shiny_carnival_items_ingested_total Records successfully ingested by the Analytics Service.")
+	fmt.Fprintln(w, "# TYPE shiny_carnival_items_ingested_total counter")
+	fmt.Fprintf(w, "shiny_carnival_items_ingested_total %d\n", atomic.LoadInt64(&itemsIngested))
+
+	fmt.Fprintln(w, "# HELP shiny_carnival_dlq_size Batches currently parked in the dead-letter file.")
+	fmt.Fprintln(w, "# TYPE shiny_carnival_dlq_size gauge")
+	fmt.Fprintf(w, "shiny_carnival_dlq_size %d\n", atomic.LoadInt64(&dlqSize))
+
+	fmt.Fprintln(w, "# HELP shiny_carnival_in_flight Records currently buffered awaiting a flush to Analytics.")
+	fmt.Fprintln(w, "# TYPE shiny_carnival_in_flight gauge")
+	fmt.Fprintf(w, "shiny_carnival_in_flight %d\n", recordBuffer.Len())
+}
+
+func sendToAnalytics(ctx context.Context, records []Record) error {
+	url := "https://api.heyering.com/analytics"
+	requestBody, err := json.Marshal(records)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("Sending batch of %d records to Analytics Service", len(records))
+
+	resp, err := analyticsClient.Do(ctx, "analytics", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		req.Header.Set("Authorization", "eye-am-hiring")
+		return req, nil
+	})
+	if err != nil {
+		return fmt.Errorf("calling analytics service: %w", err)
+	}
+	defer resp.Body.Close()
+
+	responseBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return err
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("analytics service returned status %d, response: %s", resp.StatusCode, responseBody)
+	}
+
+	var response struct {
+		Status        string `json:"status"`
+		ItemsIngested int    `json:"itemsIngested"`
+	}
+	if err := json.Unmarshal(responseBody, &response); err != nil {
+		return err
+	}
+
+	log.Printf("Successfully sent batch to Analytics Service. Items Ingested: %d", response.ItemsIngested)
+	return nil
+}
@@ -0,0 +1,172 @@
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"math"
+	"math/bits"
+	"os"
+	"sync"
+)
+
+// bloomFilter is a counting-free Bloom filter sized from an expected
+// cardinality and target false-positive rate. It is used purely to skip
+// re-enrichment of records we've already sent to the billed enrichment
+// endpoint: a definite-miss means "never seen", a hit means "probably
+// seen before". It is never used to drop records from analytics — a
+// false positive only costs a skipped enrichment call, not a lost record.
+type bloomFilter struct {
+	mu   sync.Mutex
+	bits []uint64
+	m    uint64 // number of bits
+	k    uint64 // number of hash functions
+}
+
+// newBloomFilter sizes the filter with the standard formulas:
+// m = -n*ln(p) / (ln2)^2 bits, k = (m/n)*ln2 hash functions.
+func newBloomFilter(expectedN uint64, falsePositiveRate float64) *bloomFilter {
+	if expectedN == 0 {
+		expectedN = 1
+	}
+	n := float64(expectedN)
+	m := math.Ceil(-n * math.Log(falsePositiveRate) / (math.Ln2 * math.Ln2))
+	k := math.Round((m / n) * math.Ln2)
+	if k < 1 {
+		k = 1
+	}
+
+	words := (uint64(m) + 63) / 64
+	if words == 0 {
+		words = 1
+	}
+
+	return &bloomFilter{
+		bits: make([]uint64, words),
+		m:    words * 64,
+		k:    uint64(k),
+	}
+}
+
+// hashes returns the two 64-bit halves of the SHA-256 digest of id, used as
+// the h1/h2 seeds for double hashing (h1 + i*h2 mod m).
+func (f *bloomFilter) hashes(id string) (uint64, uint64) {
+	sum := sha256.Sum256([]byte(id))
+	h1 := binary.BigEndian.Uint64(sum[0:8])
+	h2 := binary.BigEndian.Uint64(sum[8:16])
+	return h1, h2
+}
+
+func (f *bloomFilter) positions(id string) []uint64 {
+	h1, h2 := f.hashes(id)
+	positions := make([]uint64, f.k)
+	for i := uint64(0); i < f.k; i++ {
+		positions[i] = (h1 + i*h2) % f.m
+	}
+	return positions
+}
+
+// Test reports whether id is definitely absent (false) or probably present
+// (true).
+func (f *bloomFilter) Test(id string) bool {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, pos := range f.positions(id) {
+		word, bit := pos/64, pos%64
+		if f.bits[word]&(1<<bit) == 0 {
+			return false
+		}
+	}
+	return true
+}
+
+// Add marks id as seen.
+func (f *bloomFilter) Add(id string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, pos := range f.positions(id) {
+		word, bit := pos/64, pos%64
+		f.bits[word] |= 1 << bit
+	}
+}
+
+// snapshot writes the raw bitset to path via a temp-file-then-rename so a
+// crash mid-write can't corrupt the previous snapshot.
+func (f *bloomFilter) snapshot(path string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	tmp := path + ".tmp"
+	file, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+
+	w := bufio.NewWriter(file)
+	if err := binary.Write(w, binary.BigEndian, f.m); err != nil {
+		file.Close()
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, f.k); err != nil {
+		file.Close()
+		return err
+	}
+	if err := binary.Write(w, binary.BigEndian, f.bits); err != nil {
+		file.Close()
+		return err
+	}
+	if err := w.Flush(); err != nil {
+		file.Close()
+		return err
+	}
+	if err := file.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, path)
+}
+
+// loadBloomFilter restores a filter previously written by snapshot. If path
+// does not exist, it returns a fresh filter sized for expectedN/falsePositiveRate.
+func loadBloomFilter(path string, expectedN uint64, falsePositiveRate float64) (*bloomFilter, error) {
+	file, err := os.Open(path)
+	if os.IsNotExist(err) {
+		return newBloomFilter(expectedN, falsePositiveRate), nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	r := bufio.NewReader(file)
+	f := &bloomFilter{}
+	if err := binary.Read(r, binary.BigEndian, &f.m); err != nil {
+		return nil, fmt.Errorf("reading bloom filter header: %w", err)
+	}
+	if err := binary.Read(r, binary.BigEndian, &f.k); err != nil {
+		return nil, fmt.Errorf("reading bloom filter header: %w", err)
+	}
+
+	words := f.m / 64
+	f.bits = make([]uint64, words)
+	if err := binary.Read(r, binary.BigEndian, &f.bits); err != nil {
+		return nil, fmt.Errorf("reading bloom filter bitset: %w", err)
+	}
+
+	return f, nil
+}
+
+// popcount is exposed for tests to sanity-check bit density.
+func (f *bloomFilter) popcount() uint64 {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	var total uint64
+	for _, word := range f.bits {
+		total += uint64(bits.OnesCount64(word))
+	}
+	return total
+}
@@ -2,30 +2,86 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
+	"flag"
 	"fmt"
 	"io"
 	"log"
 	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
 	"time"
+
+	"github.com/nemes1s/shiny-carnival/internal/httpx"
+	"github.com/nemes1s/shiny-carnival/internal/record"
+	"github.com/nemes1s/shiny-carnival/internal/ring"
 )
 
-type Record struct {
-	ID            string `json:"id"`
-	AssetName     string `json:"asset_name"`
-	IP            string `json:"ip"`
-	CreatedUTC    string `json:"created_utc"`
-	Source        string `json:"source"`
-	Category      string `json:"category"`
-	ASN           string `json:"asn"`
-	CorrelationID int    `json:"correlationId"`
-}
+// Record is the microservice's local name for the shared wire format, kept
+// as an alias so the rest of this package doesn't need a package
+// qualifier.
+type Record = record.Record
+
+var dedupeFilter *bloomFilter
 
-var recordChannel = make(chan Record, 20)
+var enrichmentClient = httpx.New(httpx.DefaultConfig())
 
 func main() {
+	expectedN := flag.Uint64("dedupe-expected-n", 1_000_000, "Expected number of distinct record IDs, used to size the dedupe Bloom filter")
+	falsePositiveRate := flag.Float64("dedupe-fp-rate", 0.01, "Target false-positive rate for the dedupe Bloom filter")
+	snapshotPath := flag.String("dedupe-snapshot", "dedupe.bloom", "Path to persist the dedupe Bloom filter across restarts")
+	snapshotInterval := flag.Duration("dedupe-snapshot-interval", 30*time.Second, "How often to persist the dedupe Bloom filter to disk")
+	bufferSize := flag.Int("buffer-size", 1000, "Capacity of the bounded ring buffer between /process and the analytics batcher")
+	backpressure := flag.String("backpressure", "block", "What the ring buffer does when full: block or drop-oldest")
+	batchSize := flag.Int("batch-size", 20, "Flush a batch to Analytics once it reaches this many records")
+	batchMaxLatency := flag.Duration("batch-max-latency", 10*time.Second, "Flush a batch to Analytics this long after its first record arrived, even if it's not full")
+	dlqPath := flag.String("dlq-file", "dead-letter.ndjson", "NDJSON file batches are appended to when Analytics can't be reached")
+	flag.Parse()
+
+	mode := ring.Block
+	if *backpressure == "drop-oldest" {
+		mode = ring.DropOldest
+	} else if *backpressure != "block" {
+		log.Fatalf("Invalid --backpressure %q, expected block or drop-oldest", *backpressure)
+	}
+	recordBuffer = ring.New[Record](*bufferSize, mode)
+
+	filter, err := loadBloomFilter(*snapshotPath, *expectedN, *falsePositiveRate)
+	if err != nil {
+		log.Fatalf("Error loading dedupe Bloom filter from %s: %v", *snapshotPath, err)
+	}
+	dedupeFilter = filter
+
+	startAnalyticsPipeline(*batchSize, *batchMaxLatency, *dlqPath)
+
+	stop := make(chan os.Signal, 1)
+	signal.Notify(stop, syscall.SIGINT, syscall.SIGTERM)
+	go func() {
+		ticker := time.NewTicker(*snapshotInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ticker.C:
+				if err := dedupeFilter.snapshot(*snapshotPath); err != nil {
+					log.Printf("Error snapshotting dedupe Bloom filter: %v", err)
+				}
+			case <-stop:
+				log.Println("Shutting down, persisting dedupe Bloom filter...")
+				if err := dedupeFilter.snapshot(*snapshotPath); err != nil {
+					log.Printf("Error snapshotting dedupe Bloom filter: %v", err)
+				}
+				log.Println("Draining record buffer and flushing in-progress batch...")
+				stopAnalyticsPipeline()
+				os.Exit(0)
+			}
+		}
+	}()
+
 	http.HandleFunc("/process", processHandler)
-	go startSendingToAnalytics()
+	http.HandleFunc("/replay-dlq", replayDLQHandler)
+	http.HandleFunc("/metrics", metricsHandler)
 
 	log.Println("Microservice running on :8081")
 	log.Fatal(http.ListenAndServe(":8081", nil))
@@ -48,14 +104,21 @@ func processHandler(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	enrichedRecord, err := enrichRecord(record)
-	if err != nil {
-		http.Error(w, "Failed to enrich record", http.StatusInternalServerError)
-		log.Printf("Error enriching record with ID %s: %v", record.ID, err)
-		return
+	result := record
+	if !dedupeFilter.Test(record.ID) {
+		enrichedRecord, err := enrichRecord(r.Context(), record)
+		if err != nil {
+			http.Error(w, "Failed to enrich record", http.StatusInternalServerError)
+			log.Printf("Error enriching record with ID %s: %v", record.ID, err)
+			return
+		}
+		dedupeFilter.Add(record.ID)
+		result = enrichedRecord
+	} else {
+		log.Printf("Record %s is a probable dedupe hit, skipping enrichment", record.ID)
 	}
 
-	recordChannel <- enrichedRecord
+	recordBuffer.Push(result)
 	w.WriteHeader(http.StatusOK)
 
 	response := map[string]string{"status": "record processed"}
@@ -63,7 +126,7 @@ func processHandler(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(response)
 }
 
-func enrichRecord(record Record) (Record, error) {
+func enrichRecord(ctx context.Context, record Record) (Record, error) {
 	url := "https://api.heyering.com/enrichment"
 	requestBody, err := json.Marshal(map[string]interface{}{
 		"id":       record.ID,
@@ -75,126 +138,40 @@ func enrichRecord(record Record) (Record, error) {
 		return record, err
 	}
 
-	maxRetries := 3
-	retryDelay := time.Second
-	for attempt := 1; attempt <= maxRetries; attempt++ {
-		req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
+	resp, err := enrichmentClient.Do(ctx, "enrichment", func(ctx context.Context) (*http.Request, error) {
+		req, err := http.NewRequestWithContext(ctx, "POST", url, bytes.NewBuffer(requestBody))
 		if err != nil {
-			return record, err
+			return nil, err
 		}
 		req.Header.Set("Content-Type", "application/json")
 		req.Header.Set("Authorization", "eye-am-hiring")
-
-		log.Printf("Attempt %d: Calling Enrichment Service for record ID %s", attempt, record.ID)
-
-		client := &http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			log.Printf("Attempt %d: Error calling Enrichment Service: %v", attempt, err)
-		} else {
-			defer resp.Body.Close()
-			responseBody, err := io.ReadAll(resp.Body)
-			if err != nil {
-				log.Printf("Attempt %d: Error reading response body: %v", attempt, err)
-			} else if resp.StatusCode == http.StatusOK {
-				var enrichedData struct {
-					ASN           string `json:"asn"`
-					Category      string `json:"category"`
-					CorrelationID int    `json:"correlationId"`
-				}
-				if err := json.Unmarshal(responseBody, &enrichedData); err != nil {
-					log.Printf("Attempt %d: Error unmarshalling response: %v", attempt, err)
-				} else {
-					// Success
-					record.ASN = enrichedData.ASN
-					record.Category = enrichedData.Category
-					record.CorrelationID = enrichedData.CorrelationID
-					return record, nil
-				}
-			} else {
-				log.Printf("Attempt %d: Enrichment service returned status %d, response: %s", attempt, resp.StatusCode, responseBody)
-			}
-		}
-
-		if attempt < maxRetries {
-			time.Sleep(retryDelay)
-			retryDelay *= 2
-		} else {
-			return record, fmt.Errorf("failed to enrich record after %d attempts", maxRetries)
-		}
-	}
-	return record, fmt.Errorf("unexpected error during enrichment")
-}
-
-func startSendingToAnalytics() {
-	ticker := time.NewTicker(10 * time.Second)
-	defer ticker.Stop()
-
-	var batch []Record
-
-	for {
-		select {
-		case record := <-recordChannel:
-			batch = append(batch, record)
-		case <-ticker.C:
-			if len(batch) > 0 {
-				var recordsToSend []Record
-				if len(batch) >= 20 {
-					recordsToSend = batch[:20]
-					batch = batch[20:]
-				} else {
-					recordsToSend = batch
-					batch = batch[:0]
-				}
-				if err := sendToAnalytics(recordsToSend); err != nil {
-					log.Printf("Failed to send batch to Analytics Service: %v", err)
-				}
-			}
-		}
-	}
-}
-
-func sendToAnalytics(records []Record) error {
-	url := "https://api.heyering.com/analytics"
-	requestBody, err := json.Marshal(records)
-	if err != nil {
-		return err
-	}
-
-	req, err := http.NewRequest("POST", url, bytes.NewBuffer(requestBody))
-	if err != nil {
-		return err
-	}
-	req.Header.Set("Content-Type", "application/json")
-	req.Header.Set("Authorization", "eye-am-hiring")
-
-	log.Printf("Sending batch of %d records to Analytics Service", len(records))
-	log.Printf("Request Payload: %s", requestBody)
-
-	client := &http.Client{}
-	resp, err := client.Do(req)
+		return req, nil
+	})
 	if err != nil {
-		return err
+		return record, fmt.Errorf("calling enrichment service: %w", err)
 	}
 	defer resp.Body.Close()
 
 	responseBody, err := io.ReadAll(resp.Body)
 	if err != nil {
-		return err
+		return record, fmt.Errorf("reading enrichment response: %w", err)
 	}
 
 	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("analytics service returned status %d, response: %s", resp.StatusCode, responseBody)
+		return record, fmt.Errorf("enrichment service returned status %d, response: %s", resp.StatusCode, responseBody)
 	}
 
-	var response struct {
-		Status        string `json:"status"`
-		ItemsIngested int    `json:"itemsIngested"`
+	var enrichedData struct {
+		ASN           string `json:"asn"`
+		Category      string `json:"category"`
+		CorrelationID int    `json:"correlationId"`
 	}
-	if err := json.Unmarshal(responseBody, &response); err != nil {
-		return err
+	if err := json.Unmarshal(responseBody, &enrichedData); err != nil {
+		return record, fmt.Errorf("unmarshalling enrichment response: %w", err)
 	}
 
-	log.Printf("Successfully sent batch to Analytics Service. Items Ingested: %d", response.ItemsIngested)
-	return nil
+	record.ASN = enrichedData.ASN
+	record.Category = enrichedData.Category
+	record.CorrelationID = enrichedData.CorrelationID
+	return record, nil
 }